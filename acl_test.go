@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/client9/ipcat"
+)
+
+// TestIpcatACLDispatch covers the v4/v6 family dispatch in ipcatACL.Decide:
+// a v4 address with no IPv4 database aborts, a v6 address is matched against
+// the IPv6 prefix set, and with both databases configured the same rule
+// evaluates each address against the database for its own family.
+func TestIpcatACLDispatch(t *testing.T) {
+	ipset6 := NewIpcat6Set()
+	if err := ipset6.ImportCSV(strings.NewReader("2001:db8::/32,Example\n")); err != nil {
+		t.Fatalf("ImportCSV() = %v, want nil", err)
+	}
+
+	t.Run("v4 without a database aborts", func(t *testing.T) {
+		blacklist := ParseBlacklist("ipcat Example\n")
+		ctx := &BlacklistContext{IPSet6: ipset6}
+
+		if allow := blacklist.Allow(ctx, net.ParseIP("198.51.100.1"), nil); allow {
+			t.Errorf("Allow() = true, want false: a v4 address needs ctx.IPSet, which isn't configured")
+		}
+	})
+
+	t.Run("v6 matches against the IPv6 prefix set", func(t *testing.T) {
+		blacklist := ParseBlacklist("ipcat Example\n")
+		ctx := &BlacklistContext{IPSet6: ipset6}
+
+		if allow := blacklist.Allow(ctx, net.ParseIP("2001:db8::1"), nil); allow {
+			t.Errorf("Allow() = true, want false: address is inside the categorized v6 prefix")
+		}
+	})
+
+	t.Run("v6 outside the prefix set continues", func(t *testing.T) {
+		blacklist := ParseBlacklist("ipcat Example\n")
+		ctx := &BlacklistContext{IPSet6: ipset6}
+
+		if allow := blacklist.Allow(ctx, net.ParseIP("2001:db9::1"), nil); !allow {
+			t.Errorf("Allow() = false, want true: address outside the categorized prefix shouldn't match")
+		}
+	})
+
+	t.Run("mixed v4 and v6 addresses each check their own database", func(t *testing.T) {
+		ipset := ipcat.NewIntervalSet(16)
+		blacklist := ParseBlacklist("ipcat Example\n")
+		ctx := &BlacklistContext{IPSet: ipset, IPSet6: ipset6}
+
+		if allow := blacklist.Allow(ctx, net.ParseIP("2001:db8::1"), nil); allow {
+			t.Errorf("Allow() = true, want false: v6 address should still be denied once ctx.IPSet is also configured")
+		}
+
+		// A v4 address against the (empty) v4 database finds no category and
+		// falls through to the default-allow, rather than aborting now that
+		// ctx.IPSet is configured.
+		if allow := blacklist.Allow(ctx, net.ParseIP("198.51.100.1"), nil); !allow {
+			t.Errorf("Allow() = false, want true: v4 address with no matching category should default-allow")
+		}
+	})
+}
@@ -2,9 +2,10 @@ package main
 
 import (
 	"fmt"
-	"log"
 	"net"
+	"net/http"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -16,8 +17,11 @@ import (
 // used to categorize IP addresses needed for specific rules, like
 // the geolocation database used for geofencing or the ipcat database.
 type BlacklistContext struct {
-	GeoDB *geoip2.Reader
-	IPSet *ipcat.IntervalSet
+	GeoDB    *geoip2.Reader
+	ASNDB    *geoip2.Reader
+	IPSet    *ipcat.IntervalSet
+	IPSet6   *Ipcat6Set
+	Resolver *Resolver
 }
 
 // BlacklistRule is a structure that represents a rule or comment as part
@@ -32,6 +36,9 @@ type BlacklistRule struct {
 	Regexp   *regexp.Regexp
 	Geofence *Geofence
 	IPCat    string
+	ASN      string
+	Country  string
+	Expr     *ExprRule
 }
 
 func (i BlacklistRule) String() (value string) {
@@ -77,6 +84,19 @@ func (i BlacklistRule) String() (value string) {
 		value += "ipcat " + i.IPCat
 	}
 
+	if i.ASN != "" {
+		value += "asn " + i.ASN + " # ASN"
+	}
+
+	if i.Country != "" {
+		value += "country " + i.Country
+	}
+
+	if i.Expr != nil {
+		value += "expr: " + i.Expr.Source + " # " + i.Expr.RuleName
+		return
+	}
+
 	if i.Comment != "" {
 		value += "# " + i.Comment
 	}
@@ -124,10 +144,26 @@ var geofenceUnits = map[string]float64{
 }
 
 // ParseBlacklist parses a text blacklist and returns a Blacklist object.
+// A blacklist written in the YAML expression-rule format (detected by
+// IsExprFormat) is compiled by ParseExprBlacklist instead of the
+// line-oriented syntax below. If that fails to parse, the text falls back
+// to line-oriented parsing (with the error kept as a leading comment rule)
+// rather than silently producing a blacklist with no rules.
 func ParseBlacklist(text string) Blacklist {
-	lines := strings.Split(text, "\n")
 	blacklist := Blacklist{List: []*BlacklistRule{}}
 
+	if IsExprFormat(text) {
+		parsed, err := ParseExprBlacklist(text)
+		if err == nil {
+			return parsed
+		}
+		blacklist.Add(&BlacklistRule{
+			Comment: fmt.Sprintf("Error: could not parse expression blacklist: %s", err.Error()),
+		})
+	}
+
+	lines := strings.Split(text, "\n")
+
 	for _, line := range lines {
 		// A line with # serves as a comment.
 		if commentStart := strings.IndexByte(line, '#'); commentStart >= 0 {
@@ -167,6 +203,28 @@ func ParseBlacklist(text string) Blacklist {
 			continue
 		}
 
+		// ASN database query match, e.g. "asn 15169" or "asn AS15169".
+		if len(line) > 4 && strings.EqualFold(line[:4], "asn ") {
+			asn := strings.TrimSpace(line[4:])
+			asn = strings.TrimPrefix(strings.ToUpper(asn), "AS")
+			item.ASN = asn
+			blacklist.Add(item)
+			continue
+		}
+
+		// Country database query match, e.g. "country US", "country US-CA",
+		// or "country !RU" to except a country from an otherwise matched rule.
+		if len(line) > 8 && strings.EqualFold(line[:8], "country ") {
+			country := strings.TrimSpace(line[8:])
+			if strings.HasPrefix(country, "!") {
+				item.Negation = true
+				country = strings.TrimSpace(country[1:])
+			}
+			item.Country = strings.ToUpper(country)
+			blacklist.Add(item)
+			continue
+		}
+
 		switch line[0] {
 		case '@':
 			// An optional prefix "@" indicates a geofencing target.
@@ -283,129 +341,70 @@ func (b *Blacklist) Add(item *BlacklistRule) {
 }
 
 // Allow decides whether the Blacklist permits the selected IP address.
-func (b *Blacklist) Allow(ctx *BlacklistContext, ip net.IP) bool {
-	allow := true
-
-	user := (*Geofence)(nil)
-	category := (*ipcat.Interval)(nil)
-
-	for _, item := range b.List {
-		match := false
-
-		if item.All {
-			// Wildcard
-			match = true
+// Each rule is evaluated as an ACL in priority order; every Accept or
+// Reject decision overwrites the running verdict, so (as with the
+// original flat rule list) the last rule to match wins and an exception
+// rule still has to come after the broader rule it carves out of. An
+// Abort — a configuration or lookup error an ACL can't recover from — is
+// the one case that short-circuits, denying access immediately. Access is
+// allowed by default if no rule ever decides otherwise.
+func (b *Blacklist) Allow(ctx *BlacklistContext, ip net.IP, req *http.Request) bool {
+	acls := b.acls()
+
+	sort.SliceStable(acls, func(i, j int) bool {
+		return acls[i].Priority() < acls[j].Priority()
+	})
 
-		} else if item.Network != nil {
-			// IP Network
-			match = item.Network.Contains(ip)
-
-		} else if item.IP != nil {
-			// IP Address
-			match = item.IP.Equal(ip)
-
-		} else if item.Hostname != "" {
-			// Hostname
-			addrs, err := net.LookupIP(item.Hostname)
-			if err != nil {
-				for _, addr := range addrs {
-					if addr.Equal(ip) {
-						match = true
-						break
-					}
-				}
-			}
-
-			names, err := net.LookupAddr(ip.String())
-			if err != nil {
-				for _, name := range names {
-					name = strings.ToLower(name)
-					if name == item.Hostname {
-						match = true
-						break
-					}
-				}
-			}
-
-		} else if item.Regexp != nil {
-			// Regular Expression
-			names, err := net.LookupAddr(ip.String())
-			if err != nil {
-				for _, name := range names {
-					name = strings.ToLower(name)
-					if item.Regexp.Match([]byte(name)) {
-						match = true
-						break
-					}
-				}
-			}
-		} else if item.Geofence != nil {
-			if ctx.GeoDB == nil {
-				log.Println("Denying access by geofence rule error: no database provided")
-				return false
-			}
-
-			if user == nil {
-				record, err := ctx.GeoDB.City(ip)
-				if err != nil {
-					log.Printf("Denying access by geofence rule error: %s", err.Error())
-					return false
-				}
-				user = &Geofence{
-					Latitude:  record.Location.Latitude,
-					Longitude: record.Location.Longitude,
-					Radius:    float64(record.Location.AccuracyRadius) * 1000.0, // Convert km to m
-				}
-			}
-
-			bounds := item.Geofence
-			boundsIntersect := bounds.Intersection(user)
-			if item.Negation {
-				// Whitelist if user is completely contained within bounds
-				match = boundsIntersect&IsSuperset != 0
-			} else {
-				// Blacklist if user intersects at all with bounds
-				match = !(boundsIntersect&IsDisjoint != 0)
-			}
-		} else if item.IPCat != "" {
-			if ctx.IPSet == nil {
-				log.Println("Denying access by ipcat rule error: no database provided")
-				return false
-			}
-
-			if category == nil {
-				ipv4 := ip.To4()
-				if ipv4 != nil {
-					dots := ipv4.String()
-					interval, err := ctx.IPSet.Contains(dots)
-					if err != nil {
-						log.Printf("Denying access by ipcat rule error: %s", err.Error())
-						return false
-					}
-					category = interval
-				}
-			}
-
-			if category != nil {
-				var err error
-
-				name := strings.ToLower(category.Name)
-				search := strings.Replace(regexp.QuoteMeta(strings.ToLower(item.IPCat)), `\*`, `.*`, -1)
-				match, err = regexp.MatchString(search, name)
-				if err != nil {
-					log.Printf("Denying access by ipcat rule error: %s", err.Error())
-					return false
-				}
-			}
+	allow := true
 
+	for _, acl := range acls {
+		switch acl.Decide(ctx, ip, req) {
+		case Accept:
+			allow = true
+		case Reject:
+			allow = false
+		case Abort:
 			return false
 		}
+	}
+
+	return allow
+}
+
+// acls builds the pluggable ACL pipeline for the blacklist's rules. Rules
+// default to their position in the list as their priority, so evaluation
+// order matches file order (today, the only order any rule kind uses)
+// unless a future rule kind overrides Priority() to jump the queue.
+func (b *Blacklist) acls() []ACL {
+	cache := &ruleCache{}
+	acls := make([]ACL, 0, len(b.List))
 
-		// TODO: Allow early termination based on negation flags
-		if match {
-			allow = item.Negation
+	for index, item := range b.List {
+		priority := uint(index)
+
+		switch {
+		case item.All:
+			acls = append(acls, &wildcardACL{item, priority})
+		case item.Network != nil:
+			acls = append(acls, &networkACL{item, priority})
+		case item.IP != nil:
+			acls = append(acls, &ipACL{item, priority})
+		case item.Hostname != "":
+			acls = append(acls, &hostnameACL{item, priority})
+		case item.Regexp != nil:
+			acls = append(acls, &regexpACL{item, priority})
+		case item.Geofence != nil:
+			acls = append(acls, &geofenceACL{item, priority, cache})
+		case item.IPCat != "":
+			acls = append(acls, &ipcatACL{item, priority, cache})
+		case item.ASN != "":
+			acls = append(acls, &asnACL{item, priority, cache})
+		case item.Country != "":
+			acls = append(acls, &countryACL{item, priority, cache})
+		case item.Expr != nil:
+			acls = append(acls, &exprACL{item, priority})
 		}
 	}
 
-	return allow
+	return acls
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+// TestParseBlacklistCountryNegation confirms "country !RU" parses as a
+// negated country rule rather than a literal (and never-matching) country
+// code of "!RU".
+func TestParseBlacklistCountryNegation(t *testing.T) {
+	blacklist := ParseBlacklist("country !RU\n")
+
+	if len(blacklist.List) != 1 {
+		t.Fatalf("len(blacklist.List) = %d, want 1", len(blacklist.List))
+	}
+
+	item := blacklist.List[0]
+	if !item.Negation {
+		t.Errorf("item.Negation = false, want true")
+	}
+	if item.Country != "RU" {
+		t.Errorf("item.Country = %q, want %q", item.Country, "RU")
+	}
+}
+
+// TestParseBlacklistCountrySubdivision confirms "country US-CA" parses into
+// a rule carrying the full subdivision code, which countryACL.Decide then
+// resolves via the City (not Country) database lookup.
+func TestParseBlacklistCountrySubdivision(t *testing.T) {
+	blacklist := ParseBlacklist("country US-CA\n")
+
+	if len(blacklist.List) != 1 {
+		t.Fatalf("len(blacklist.List) = %d, want 1", len(blacklist.List))
+	}
+
+	if country := blacklist.List[0].Country; country != "US-CA" {
+		t.Errorf("item.Country = %q, want %q", country, "US-CA")
+	}
+}
+
+// TestParseBlacklistASN confirms "asn 15169" and "asn AS15169" both parse
+// into the bare numeric ASN string.
+func TestParseBlacklistASN(t *testing.T) {
+	for _, text := range []string{"asn 15169\n", "asn AS15169\n"} {
+		blacklist := ParseBlacklist(text)
+
+		if len(blacklist.List) != 1 {
+			t.Fatalf("ParseBlacklist(%q): len(blacklist.List) = %d, want 1", text, len(blacklist.List))
+		}
+		if asn := blacklist.List[0].ASN; asn != "15169" {
+			t.Errorf("ParseBlacklist(%q): item.ASN = %q, want %q", text, asn, "15169")
+		}
+	}
+}
+
+// TestAllowASNAbortsWithoutDatabase confirms an ASN rule denies immediately
+// when no ASN database is configured, rather than silently matching
+// nothing.
+func TestAllowASNAbortsWithoutDatabase(t *testing.T) {
+	blacklist := ParseBlacklist("asn 15169\n")
+	ctx := &BlacklistContext{}
+
+	if allow := blacklist.Allow(ctx, net.ParseIP("8.8.8.8"), nil); allow {
+		t.Errorf("Allow() = true, want false: an ASN rule with no database must deny by default")
+	}
+}
+
+// TestAllowNegationException pins the "block a broad range, then except one
+// address" idiom: the exception rule comes after the broad rule in the file,
+// and must still win even though it was evaluated later.
+func TestAllowNegationException(t *testing.T) {
+	blacklist := ParseBlacklist("10.0.0.0/8\n!10.0.0.5\n")
+	ctx := &BlacklistContext{}
+
+	if allow := blacklist.Allow(ctx, net.ParseIP("10.0.0.5"), nil); !allow {
+		t.Errorf("Allow() = false, want true: exception rule after a broad denial should carve out its address")
+	}
+
+	if allow := blacklist.Allow(ctx, net.ParseIP("10.0.0.6"), nil); allow {
+		t.Errorf("Allow() = true, want false: addresses other than the exception should stay denied")
+	}
+}
+
+// TestAllowAbortsOnMissingDatabase confirms a rule that can't be evaluated
+// (here, a country rule with no geolocation database configured) denies
+// access immediately rather than silently falling through.
+func TestAllowAbortsOnMissingDatabase(t *testing.T) {
+	blacklist := ParseBlacklist("country US\n")
+	ctx := &BlacklistContext{}
+
+	if allow := blacklist.Allow(ctx, net.ParseIP("1.2.3.4"), nil); allow {
+		t.Errorf("Allow() = true, want false: a rule that can't be evaluated must deny by default")
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+	"gopkg.in/yaml.v3"
+)
+
+// ExprRule is a single compiled expression-language rule, as opposed to
+// one parsed from the line-oriented blacklist syntax. Its Program is
+// compiled once by ParseExprBlacklist and reused for every request.
+type ExprRule struct {
+	RuleName string
+	Source   string
+	Action   string
+	Program  *vm.Program
+}
+
+// exprRuleSpec is the YAML shape of a single rule entry in the
+// expression-rule format, e.g.:
+//
+//	- name: block-tor
+//	  expr: ip.country() == "RU" || ip.ipcat() matches "Tor.*"
+//	  action: deny
+//
+// The lowercase ip/dns/geo names used in expr are mapped from ExprEnv's
+// exported IP/Dns/Geo fields via their `expr:"..."` struct tags below.
+type exprRuleSpec struct {
+	Name   string `yaml:"name"`
+	Expr   string `yaml:"expr"`
+	Action string `yaml:"action"`
+}
+
+// IsExprFormat reports whether a blacklist's text is written in the YAML
+// expression-rule format rather than the line-oriented syntax: its first
+// non-blank line starts a YAML sequence ("-") or an "expr:" mapping key.
+// Only the first line is checked, so a line-format rule whose trailing
+// comment happens to mention "expr:" isn't misdetected.
+func IsExprFormat(text string) bool {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		return line[0] == '-' || strings.HasPrefix(line, "expr:")
+	}
+	return false
+}
+
+// ParseExprBlacklist parses the YAML expression-rule format, either a
+// sequence of rule entries or a single entry mapping, and compiles each
+// entry's expression into a reusable program. A rule whose action is
+// "allow" or "accept" behaves like a negated line rule: a match whitelists
+// the request instead of denying it.
+func ParseExprBlacklist(text string) (Blacklist, error) {
+	var specs []exprRuleSpec
+	if err := yaml.Unmarshal([]byte(text), &specs); err != nil {
+		var spec exprRuleSpec
+		if yaml.Unmarshal([]byte(text), &spec) != nil {
+			return Blacklist{}, err
+		}
+		specs = []exprRuleSpec{spec}
+	}
+
+	blacklist := Blacklist{List: []*BlacklistRule{}}
+
+	for _, spec := range specs {
+		program, err := expr.Compile(spec.Expr, expr.Env(ExprEnv{}), expr.AsBool())
+		if err != nil {
+			blacklist.Add(&BlacklistRule{
+				Comment: "Error: " + spec.Name + ": could not compile expression: " + err.Error(),
+			})
+			continue
+		}
+
+		action := strings.ToLower(spec.Action)
+		blacklist.Add(&BlacklistRule{
+			Negation: action == "allow" || action == "accept",
+			Expr: &ExprRule{
+				RuleName: spec.Name,
+				Source:   spec.Expr,
+				Action:   action,
+				Program:  program,
+			},
+		})
+	}
+
+	return blacklist, nil
+}
+
+// ExprEnv is the expression environment exposed to compiled blacklist
+// rules: ip.*, dns.*, and geo.* helpers backed by the BlacklistContext and
+// the request IP currently being evaluated. The `expr:"..."` tags give the
+// fields their lowercase rule-language names; expr-lang otherwise exposes
+// Go exported field names verbatim, which wouldn't match the documented
+// ip./dns./geo. syntax.
+type ExprEnv struct {
+	IP  ExprIPEnv  `expr:"ip"`
+	Dns ExprDNSEnv `expr:"dns"`
+	Geo ExprGeoEnv `expr:"geo"`
+}
+
+// ExprIPEnv exposes categorization lookups for the request IP.
+type ExprIPEnv struct {
+	ctx *BlacklistContext
+	ip  net.IP
+}
+
+// Asn returns the autonomous system number of the request IP, or 0 if it
+// could not be determined.
+func (e ExprIPEnv) Asn() int {
+	if e.ctx == nil || e.ctx.ASNDB == nil {
+		return 0
+	}
+	record, err := e.ctx.ASNDB.ASN(e.ip)
+	if err != nil {
+		return 0
+	}
+	return int(record.AutonomousSystemNumber)
+}
+
+// Country returns the two-letter ISO country code of the request IP, or
+// "" if it could not be determined.
+func (e ExprIPEnv) Country() string {
+	if e.ctx == nil || e.ctx.GeoDB == nil {
+		return ""
+	}
+	record, err := e.ctx.GeoDB.Country(e.ip)
+	if err != nil {
+		return ""
+	}
+	return record.Country.IsoCode
+}
+
+// Ipcat returns the ipcat network category name of the request IP, or ""
+// if it could not be determined. Only the IPv4 database is consulted, so an
+// IPv6 request IP always yields "".
+func (e ExprIPEnv) Ipcat() string {
+	if e.ctx == nil || e.ctx.IPSet == nil {
+		return ""
+	}
+	ipv4 := e.ip.To4()
+	if ipv4 == nil {
+		return ""
+	}
+	interval, err := e.ctx.IPSet.Contains(ipv4.String())
+	if err != nil || interval == nil {
+		return ""
+	}
+	return interval.Name
+}
+
+// ExprDNSEnv exposes forward and reverse DNS lookups, routed through the
+// BlacklistContext's Resolver so repeated lookups hit its TTL cache and
+// honor the request's context cancellation, instead of resolving directly
+// and reintroducing the DoS amplifier the Resolver was added to close.
+type ExprDNSEnv struct {
+	ctx *BlacklistContext
+	ip  net.IP
+	req context.Context
+}
+
+// Ptr returns the reverse-resolved hostnames of the request IP.
+func (e ExprDNSEnv) Ptr() []string {
+	if e.ctx == nil || e.ctx.Resolver == nil {
+		return nil
+	}
+	names, err := e.ctx.Resolver.LookupAddr(e.req, e.ip.String())
+	if err != nil {
+		return nil
+	}
+	return names
+}
+
+// A returns the forward-resolved IP addresses of the given hostname.
+func (e ExprDNSEnv) A(hostname string) []string {
+	if e.ctx == nil || e.ctx.Resolver == nil {
+		return nil
+	}
+	addrs, err := e.ctx.Resolver.LookupIP(e.req, hostname)
+	if err != nil {
+		return nil
+	}
+	values := make([]string, len(addrs))
+	for i, addr := range addrs {
+		values[i] = addr.String()
+	}
+	return values
+}
+
+// ExprGeoEnv exposes geolocation helpers for the request IP.
+type ExprGeoEnv struct {
+	ctx *BlacklistContext
+	ip  net.IP
+}
+
+// Distance returns the great-circle distance, in meters, between the
+// request IP's location and the given latitude/longitude.
+func (e ExprGeoEnv) Distance(lat, lng float64) float64 {
+	if e.ctx == nil || e.ctx.GeoDB == nil {
+		return 0
+	}
+	record, err := e.ctx.GeoDB.City(e.ip)
+	if err != nil {
+		return 0
+	}
+	user := &Geofence{Latitude: record.Location.Latitude, Longitude: record.Location.Longitude}
+	target := &Geofence{Latitude: lat, Longitude: lng}
+	return user.DistanceTo(target)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+// TestIsExprFormatIgnoresTrailingComment confirms a line-format rule whose
+// comment happens to mention "expr:" isn't misdetected as the YAML format -
+// only the first non-blank line decides.
+func TestIsExprFormatIgnoresTrailingComment(t *testing.T) {
+	text := "10.0.0.0/8 # blocked network, see expr: docs\n"
+
+	if IsExprFormat(text) {
+		t.Fatalf("IsExprFormat(%q) = true, want false", text)
+	}
+
+	blacklist := ParseBlacklist(text)
+	if allow := blacklist.Allow(&BlacklistContext{}, net.ParseIP("10.1.2.3"), nil); allow {
+		t.Errorf("Allow() = true, want false: the CIDR rule must still deny, not fail open")
+	}
+}
+
+// TestIsExprFormatDetectsLeadingSequence confirms the YAML expression
+// format is still recognized when it's actually the first line.
+func TestIsExprFormatDetectsLeadingSequence(t *testing.T) {
+	text := "- name: block-tor\n  expr: ip.asn() == 1234\n  action: deny\n"
+
+	if !IsExprFormat(text) {
+		t.Fatalf("IsExprFormat(%q) = false, want true", text)
+	}
+}
+
+// TestParseExprBlacklistRoundTrip confirms the YAML expression format
+// compiles into an Expr rule with the right name, source, and negation,
+// derived from the rule's action.
+func TestParseExprBlacklistRoundTrip(t *testing.T) {
+	text := "- name: allow-office\n  expr: ip.asn() == 64512\n  action: allow\n"
+
+	blacklist, err := ParseExprBlacklist(text)
+	if err != nil {
+		t.Fatalf("ParseExprBlacklist() = %v, want nil", err)
+	}
+
+	if len(blacklist.List) != 1 {
+		t.Fatalf("len(blacklist.List) = %d, want 1", len(blacklist.List))
+	}
+
+	rule := blacklist.List[0]
+	if rule.Expr == nil {
+		t.Fatalf("rule.Expr = nil, want non-nil")
+	}
+	if rule.Expr.RuleName != "allow-office" {
+		t.Errorf("rule.Expr.RuleName = %q, want %q", rule.Expr.RuleName, "allow-office")
+	}
+	if !rule.Negation {
+		t.Errorf("rule.Negation = false, want true: action \"allow\" should behave like a negated rule")
+	}
+}
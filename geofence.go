@@ -23,11 +23,17 @@ const (
 	IsSuperset
 )
 
-// Intersection describes the relationship between two geofences
-func (mi *Geofence) Intersection(tu *Geofence) (i SetIntersection) {
+// DistanceTo returns the great-circle distance, in meters, between two
+// geofences' center points.
+func (mi *Geofence) DistanceTo(tu *Geofence) float64 {
 	miPoint := geo.NewPoint(mi.Latitude, mi.Longitude)
 	tuPoint := geo.NewPoint(tu.Latitude, tu.Longitude)
-	distance := miPoint.GreatCircleDistance(tuPoint) * 1000
+	return miPoint.GreatCircleDistance(tuPoint) * 1000
+}
+
+// Intersection describes the relationship between two geofences
+func (mi *Geofence) Intersection(tu *Geofence) (i SetIntersection) {
+	distance := mi.DistanceTo(tu)
 
 	ourRadius := mi.Radius + tu.Radius
 	if ourRadius > distance {
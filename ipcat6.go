@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"net/netip"
+	"strings"
+)
+
+// Ipcat6Entry maps a single IPv6 network prefix to the category name ipcat
+// would report for an equivalent IPv4 range, e.g. a datacenter or cloud
+// provider's published allocation.
+type Ipcat6Entry struct {
+	Prefix netip.Prefix
+	Name   string
+}
+
+// Ipcat6Set is the IPv6 analogue of ipcat.IntervalSet. ipcat's upstream
+// CSV format stores IPv4 ranges as 32-bit integer intervals, which has no
+// natural IPv6 equivalent, so IPv6 categorization is loaded from a
+// separate "prefix,name" CSV and checked by containment instead.
+type Ipcat6Set struct {
+	entries []Ipcat6Entry
+}
+
+// NewIpcat6Set constructs an empty Ipcat6Set.
+func NewIpcat6Set() *Ipcat6Set {
+	return &Ipcat6Set{}
+}
+
+// ImportCSV loads "prefix,name" rows into the set, skipping malformed
+// rows rather than failing the whole import.
+func (s *Ipcat6Set) ImportCSV(r io.Reader) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if len(record) < 2 {
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(record[0]))
+		if err != nil {
+			continue
+		}
+
+		s.entries = append(s.entries, Ipcat6Entry{
+			Prefix: prefix,
+			Name:   strings.TrimSpace(record[1]),
+		})
+	}
+
+	return nil
+}
+
+// Contains returns the most specific entry whose prefix contains addr, or
+// nil if no entry matches.
+func (s *Ipcat6Set) Contains(addr netip.Addr) *Ipcat6Entry {
+	var best *Ipcat6Entry
+
+	for i := range s.entries {
+		entry := &s.entries[i]
+		if !entry.Prefix.Contains(addr) {
+			continue
+		}
+		if best == nil || entry.Prefix.Bits() > best.Prefix.Bits() {
+			best = entry
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestIpcat6SetContains(t *testing.T) {
+	set := NewIpcat6Set()
+	err := set.ImportCSV(strings.NewReader(
+		"2001:db8::/32,Example\n" +
+			"2001:db8:1::/48,Example Narrow\n",
+	))
+	if err != nil {
+		t.Fatalf("ImportCSV() = %v, want nil", err)
+	}
+
+	// Most specific matching prefix wins.
+	addr := netip.MustParseAddr("2001:db8:1::1")
+	entry := set.Contains(addr)
+	if entry == nil || entry.Name != "Example Narrow" {
+		t.Errorf("Contains(%s) = %v, want Example Narrow", addr, entry)
+	}
+
+	// Falls back to the broader prefix outside the narrow one.
+	addr = netip.MustParseAddr("2001:db8:2::1")
+	entry = set.Contains(addr)
+	if entry == nil || entry.Name != "Example" {
+		t.Errorf("Contains(%s) = %v, want Example", addr, entry)
+	}
+
+	// No prefix matches at all.
+	addr = netip.MustParseAddr("2001:db9::1")
+	if entry := set.Contains(addr); entry != nil {
+		t.Errorf("Contains(%s) = %v, want nil", addr, entry)
+	}
+}
+
+func TestIpcat6SetImportCSVSkipsMalformedRows(t *testing.T) {
+	set := NewIpcat6Set()
+	err := set.ImportCSV(strings.NewReader(
+		"not-a-prefix,Bad\n" +
+			"2001:db8::/32,Good\n",
+	))
+	if err != nil {
+		t.Fatalf("ImportCSV() = %v, want nil", err)
+	}
+
+	if entry := set.Contains(netip.MustParseAddr("2001:db8::1")); entry == nil || entry.Name != "Good" {
+		t.Errorf("Contains() = %v, want Good (malformed row should be skipped, not fail the import)", entry)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// resolverCacheCapacity bounds how many hostnames/IPs the Resolver keeps
+// cached at once, evicting the least-recently-used entry past that.
+const resolverCacheCapacity = 4096
+
+// resolverRefreshThreshold is the number of cache hits an entry needs
+// before Resolver.Refresh proactively re-resolves it ahead of expiry.
+const resolverRefreshThreshold = 5
+
+// ResolverConfig controls how long the Resolver caches lookup results.
+type ResolverConfig struct {
+	PositiveTTL time.Duration
+	NegativeTTL time.Duration
+}
+
+// DefaultResolverConfig is used by NewShotHandler when no other
+// configuration is supplied.
+var DefaultResolverConfig = ResolverConfig{
+	PositiveTTL: 5 * time.Minute,
+	NegativeTTL: 30 * time.Second,
+}
+
+// Resolver is an LRU+TTL cache over forward and reverse DNS lookups, so
+// that hostname and regular-expression blacklist rules don't re-resolve
+// DNS on every hit. Failed lookups are cached too (with a shorter TTL) to
+// avoid repeatedly hammering a broken or unreachable resolver.
+type Resolver struct {
+	config ResolverConfig
+
+	forward *ttlCache
+	reverse *ttlCache
+}
+
+// NewResolver constructs a Resolver using the given cache TTLs.
+func NewResolver(config ResolverConfig) *Resolver {
+	return &Resolver{
+		config:  config,
+		forward: newTTLCache(resolverCacheCapacity),
+		reverse: newTTLCache(resolverCacheCapacity),
+	}
+}
+
+// LookupIP resolves hostname's forward IP addresses, consulting the cache
+// first. It honors ctx cancellation so a slow lookup can't outlive the
+// request that triggered it.
+func (r *Resolver) LookupIP(ctx context.Context, hostname string) ([]net.IP, error) {
+	if entry, ok := r.forward.get(hostname); ok {
+		return stringsToIPs(entry.values), entry.err
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, hostname)
+
+	ips := make([]net.IP, len(addrs))
+	values := make([]string, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+		values[i] = addr.IP.String()
+	}
+
+	r.forward.set(hostname, values, err, r.ttlFor(err))
+	return ips, err
+}
+
+// LookupAddr resolves ip's reverse hostnames, consulting the cache first.
+// It honors ctx cancellation so a slow lookup can't outlive the request
+// that triggered it.
+func (r *Resolver) LookupAddr(ctx context.Context, ip string) ([]string, error) {
+	if entry, ok := r.reverse.get(ip); ok {
+		return entry.values, entry.err
+	}
+
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip)
+	r.reverse.set(ip, names, err, r.ttlFor(err))
+	return names, err
+}
+
+// ttlFor returns the positive or negative TTL depending on whether a
+// lookup succeeded.
+func (r *Resolver) ttlFor(err error) time.Duration {
+	if err != nil {
+		return r.config.NegativeTTL
+	}
+	return r.config.PositiveTTL
+}
+
+// Refresh runs until ctx is done, periodically re-resolving forward
+// lookups that have been hit resolverRefreshThreshold times or more, so
+// frequently-matched hostname rules stay warm instead of expiring under
+// load.
+func (r *Resolver) Refresh(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, hostname := range r.forward.frequent(resolverRefreshThreshold) {
+				r.forward.forget(hostname)
+				r.LookupIP(ctx, hostname)
+			}
+		}
+	}
+}
+
+func stringsToIPs(values []string) []net.IP {
+	ips := make([]net.IP, 0, len(values))
+	for _, value := range values {
+		if ip := net.ParseIP(value); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// ttlCacheEntry is a single cached lookup result.
+type ttlCacheEntry struct {
+	values  []string
+	err     error
+	expires time.Time
+	hits    int
+}
+
+// ttlCache is a small LRU cache of TTL-bounded lookup results, shared by
+// Resolver's forward and reverse caches.
+type ttlCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*ttlCacheEntry
+	order    []string // least-recently-used first
+}
+
+func newTTLCache(capacity int) *ttlCache {
+	return &ttlCache{
+		capacity: capacity,
+		entries:  make(map[string]*ttlCacheEntry),
+	}
+}
+
+// get returns the cached entry for key, if present and not expired.
+func (c *ttlCache) get(key string) (*ttlCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+
+	entry.hits++
+	c.touch(key)
+	return entry, true
+}
+
+// set stores values/err for key with the given ttl, evicting the
+// least-recently-used entry if the cache is at capacity.
+func (c *ttlCache) set(key string, values []string, err error, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.entries[key]; !exists && len(c.entries) >= c.capacity {
+		c.evict()
+	}
+
+	c.entries[key] = &ttlCacheEntry{values: values, err: err, expires: time.Now().Add(ttl)}
+	c.touch(key)
+}
+
+// forget removes key from the cache, so the next lookup is a cache miss.
+func (c *ttlCache) forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+	for i, k := range c.order {
+		if k == key {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// frequent returns the keys of entries hit at least threshold times.
+func (c *ttlCache) frequent(threshold int) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := make([]string, 0)
+	for key, entry := range c.entries {
+		if entry.hits >= threshold {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// touch moves key to the most-recently-used end of the order slice. The
+// caller must hold c.mu.
+func (c *ttlCache) touch(key string) {
+	for i, k := range c.order {
+		if k == key {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+	c.order = append(c.order, key)
+}
+
+// evict drops the least-recently-used entry. The caller must hold c.mu.
+func (c *ttlCache) evict() {
+	if len(c.order) == 0 {
+		return
+	}
+	oldest := c.order[0]
+	c.order = c.order[1:]
+	delete(c.entries, oldest)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestTTLCacheGetSet confirms a cached entry round-trips and carries its
+// values, error, and hit count.
+func TestTTLCacheGetSet(t *testing.T) {
+	cache := newTTLCache(4)
+	cache.set("example.com", []string{"1.2.3.4"}, nil, time.Minute)
+
+	entry, ok := cache.get("example.com")
+	if !ok {
+		t.Fatalf("get() ok = false, want true")
+	}
+	if len(entry.values) != 1 || entry.values[0] != "1.2.3.4" {
+		t.Errorf("entry.values = %v, want [1.2.3.4]", entry.values)
+	}
+
+	// A second get should bump the hit count used by frequent().
+	cache.get("example.com")
+	if keys := cache.frequent(2); len(keys) != 1 || keys[0] != "example.com" {
+		t.Errorf("frequent(2) = %v, want [example.com]", keys)
+	}
+}
+
+// TestTTLCacheExpires confirms an entry past its TTL is treated as a miss.
+func TestTTLCacheExpires(t *testing.T) {
+	cache := newTTLCache(4)
+	cache.set("example.com", []string{"1.2.3.4"}, nil, 0)
+
+	if _, ok := cache.get("example.com"); ok {
+		t.Errorf("get() ok = true, want false: a zero-TTL entry should already be expired")
+	}
+}
+
+// TestTTLCacheEvictsLeastRecentlyUsed confirms the cache drops its
+// least-recently-used entry once it's at capacity, rather than growing
+// unbounded or evicting at random.
+func TestTTLCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newTTLCache(2)
+	cache.set("a", nil, nil, time.Minute)
+	cache.set("b", nil, nil, time.Minute)
+	cache.get("a") // touch "a" so "b" becomes the least-recently-used entry
+
+	cache.set("c", nil, nil, time.Minute)
+
+	if _, ok := cache.get("b"); ok {
+		t.Errorf("get(\"b\") ok = true, want false: it should have been evicted")
+	}
+	if _, ok := cache.get("a"); !ok {
+		t.Errorf("get(\"a\") ok = false, want true: it was touched more recently than \"b\"")
+	}
+	if _, ok := cache.get("c"); !ok {
+		t.Errorf("get(\"c\") ok = false, want true")
+	}
+}
+
+// TestTTLCacheForget confirms forget removes an entry so the next lookup
+// is a cache miss.
+func TestTTLCacheForget(t *testing.T) {
+	cache := newTTLCache(4)
+	cache.set("example.com", []string{"1.2.3.4"}, nil, time.Minute)
+	cache.forget("example.com")
+
+	if _, ok := cache.get("example.com"); ok {
+		t.Errorf("get() ok = true, want false: forgotten entries should be cache misses")
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"io"
 	"log"
 	"net"
@@ -11,6 +12,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/client9/ipcat"
@@ -27,6 +29,9 @@ type ShotHandler struct {
 	Config   *ZerodropConfig
 	NotFound NotFoundHandler
 	Context  *BlacklistContext
+
+	trainersMu sync.Mutex
+	trainers   map[string]*Trainer
 }
 
 // NewShotHandler constructs a new ShotHandler from the arguments.
@@ -40,6 +45,15 @@ func NewShotHandler(db *ZerodropDB, config *ZerodropConfig, notfound NotFoundHan
 		}
 	}
 
+	var asndb *geoip2.Reader
+	if config.ASNDB != "" {
+		var err error
+		asndb, err = geoip2.Open(config.ASNDB)
+		if err != nil {
+			log.Printf("Could not open ASN database: %s", err.Error())
+		}
+	}
+
 	var ipset *ipcat.IntervalSet
 	if config.IPCat != "" {
 		reader, err := os.Open(config.IPCat)
@@ -55,17 +69,53 @@ func NewShotHandler(db *ZerodropDB, config *ZerodropConfig, notfound NotFoundHan
 		}
 	}
 
+	var ipset6 *Ipcat6Set
+	if config.IPCat6 != "" {
+		reader, err := os.Open(config.IPCat6)
+		if err != nil {
+			log.Printf("Could not open IPv6 ipcat database: %s", err.Error())
+		} else {
+			ipset6 = NewIpcat6Set()
+			err := ipset6.ImportCSV(reader)
+			if err != nil {
+				log.Printf("Could not import IPv6 ipcat database: %s", err.Error())
+				ipset6 = nil
+			}
+		}
+	}
+
+	resolver := NewResolver(DefaultResolverConfig)
+	go resolver.Refresh(context.Background(), time.Minute)
+
 	return &ShotHandler{
 		DB:       db,
 		Config:   config,
 		NotFound: notfound,
 		Context: &BlacklistContext{
-			GeoDB: geodb,
-			IPSet: ipset,
+			GeoDB:    geodb,
+			ASNDB:    asndb,
+			IPSet:    ipset,
+			IPSet6:   ipset6,
+			Resolver: resolver,
 		},
+		trainers: make(map[string]*Trainer),
 	}
 }
 
+// trainerFor returns the Trainer accumulating training scores for entry,
+// constructing one from its training knobs on first use.
+func (a *ShotHandler) trainerFor(entry *ZerodropEntry) *Trainer {
+	a.trainersMu.Lock()
+	defer a.trainersMu.Unlock()
+
+	trainer, ok := a.trainers[entry.Name]
+	if !ok {
+		trainer = NewTrainer(entry.TrainWindow, entry.TrainThreshold, entry.TrainScope)
+		a.trainers[entry.Name] = trainer
+	}
+	return trainer
+}
+
 // Access returns the ZerodropEntry with the specified name as long as access
 // is permitted. The function returns nil otherwise.
 func (a *ShotHandler) Access(name string, request *http.Request) *ZerodropEntry {
@@ -89,30 +139,12 @@ func (a *ShotHandler) Access(name string, request *http.Request) *ZerodropEntry
 	}
 
 	if entry.AccessTrain {
-		date := time.Now().Format(time.RFC1123)
-		entry.AccessBlacklist.Add(&BlacklistRule{Comment: "Automatically added by training on " + date})
-
-		// We need to add the ip to the blacklist
-		entry.AccessBlacklist.Add(&BlacklistRule{IP: ip})
-
-		// We will also add the Geofence
-		if a.Context.GeoDB != nil {
-			record, err := a.Context.GeoDB.City(ip)
-			if err == nil {
-				entry.AccessBlacklist.Add(&BlacklistRule{
-					Geofence: &Geofence{
-						Latitude:  record.Location.Latitude,
-						Longitude: record.Location.Longitude,
-						Radius:    float64(record.Location.AccuracyRadius) * 1000.0, // Convert km to m
-					},
-				})
+		if rule := a.trainerFor(&entry).Score(a.Context, ip); rule != nil {
+			entry.AccessBlacklist.Add(rule)
+			if err := entry.Update(); err != nil {
+				log.Printf("Error adding to blacklist: %s", err.Error())
 			}
 		}
-
-		if err := entry.Update(); err != nil {
-			log.Printf("Error adding to blacklist: %s", err.Error())
-			return nil
-		}
 		return nil
 	}
 
@@ -123,7 +155,7 @@ func (a *ShotHandler) Access(name string, request *http.Request) *ZerodropEntry
 		return nil
 	}
 
-	if !entry.AccessBlacklist.Allow(a.Context, ip) {
+	if !entry.AccessBlacklist.Allow(a.Context, ip, request) {
 		log.Printf("Access restricted to %s from blacklisted %s", entry.Name, ip.String())
 		entry.AccessBlacklistCount++
 		entry.Update()
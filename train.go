@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// TrainScope selects the granularity at which training mode groups hits
+// before a candidate can cross the promotion threshold.
+type TrainScope string
+
+const (
+	// TrainScopeIP scores hits per individual IP address.
+	TrainScopeIP TrainScope = "ip"
+
+	// TrainScopeASN scores hits per autonomous system number.
+	TrainScopeASN TrainScope = "asn"
+
+	// TrainScopeCountry scores hits per country.
+	TrainScopeCountry TrainScope = "country"
+
+	// TrainScopeGeo scores hits per geofence cell around the IP's location.
+	TrainScopeGeo TrainScope = "geo"
+)
+
+// DefaultTrainWindow and DefaultTrainThreshold are used when an entry
+// doesn't configure its own training knobs.
+const (
+	DefaultTrainWindow    = 10 * time.Minute
+	DefaultTrainThreshold = 5
+)
+
+// trainCandidate is a single scored, not-yet-promoted training key, e.g.
+// one IP address, ASN, country, or geofence cell.
+type trainCandidate struct {
+	count    int
+	lastSeen time.Time
+}
+
+// decayed reports whether the candidate hasn't been seen within window,
+// so transient traffic doesn't permanently accumulate toward promotion.
+func (c *trainCandidate) decayed(window time.Duration, now time.Time) bool {
+	return now.Sub(c.lastSeen) > window
+}
+
+// Trainer scores repeated hits to an entry under training and promotes a
+// candidate to a real BlacklistRule once it crosses the configured
+// threshold, rather than blacklisting on the very first hit.
+type Trainer struct {
+	Window    time.Duration
+	Threshold int
+	Scope     TrainScope
+
+	mu         sync.Mutex
+	candidates map[string]*trainCandidate
+}
+
+// NewTrainer constructs a Trainer, falling back to DefaultTrainWindow,
+// DefaultTrainThreshold, and TrainScopeIP for zero values.
+func NewTrainer(window time.Duration, threshold int, scope TrainScope) *Trainer {
+	if window <= 0 {
+		window = DefaultTrainWindow
+	}
+	if threshold <= 0 {
+		threshold = DefaultTrainThreshold
+	}
+	if scope == "" {
+		scope = TrainScopeIP
+	}
+
+	return &Trainer{
+		Window:     window,
+		Threshold:  threshold,
+		Scope:      scope,
+		candidates: make(map[string]*trainCandidate),
+	}
+}
+
+// Score records a hit from ip and returns the BlacklistRule to promote
+// once the corresponding candidate's score reaches Threshold, or nil if
+// it hasn't yet. A candidate that hasn't been seen within Window is reset
+// rather than accumulating forever, so transient traffic decays away
+// instead of permanently poisoning the blacklist.
+func (t *Trainer) Score(ctx *BlacklistContext, ip net.IP) *BlacklistRule {
+	key, rule := t.candidateKey(ctx, ip)
+	if key == "" {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	candidate, ok := t.candidates[key]
+	if !ok || candidate.decayed(t.Window, now) {
+		candidate = &trainCandidate{}
+		t.candidates[key] = candidate
+	}
+
+	candidate.count++
+	candidate.lastSeen = now
+
+	if candidate.count < t.Threshold {
+		return nil
+	}
+
+	delete(t.candidates, key)
+	return rule
+}
+
+// candidateKey returns the scoring key for ip under Scope, along with the
+// BlacklistRule that would be promoted for that key, or "" if the
+// required database isn't available.
+func (t *Trainer) candidateKey(ctx *BlacklistContext, ip net.IP) (string, *BlacklistRule) {
+	switch t.Scope {
+	case TrainScopeASN:
+		if ctx.ASNDB == nil {
+			return "", nil
+		}
+		record, err := ctx.ASNDB.ASN(ip)
+		if err != nil {
+			return "", nil
+		}
+		number := strconv.FormatUint(uint64(record.AutonomousSystemNumber), 10)
+		return "asn:" + number, &BlacklistRule{
+			ASN:     number,
+			Comment: trainComment("ASN " + number),
+		}
+
+	case TrainScopeCountry:
+		if ctx.GeoDB == nil {
+			return "", nil
+		}
+		record, err := ctx.GeoDB.Country(ip)
+		if err != nil || record.Country.IsoCode == "" {
+			return "", nil
+		}
+		code := record.Country.IsoCode
+		return "country:" + code, &BlacklistRule{
+			Country: code,
+			Comment: trainComment("country " + code),
+		}
+
+	case TrainScopeGeo:
+		if ctx.GeoDB == nil {
+			return "", nil
+		}
+		record, err := ctx.GeoDB.City(ip)
+		if err != nil {
+			return "", nil
+		}
+		geofence := &Geofence{
+			Latitude:  record.Location.Latitude,
+			Longitude: record.Location.Longitude,
+			Radius:    float64(record.Location.AccuracyRadius) * 1000.0, // Convert km to m
+		}
+		key := fmt.Sprintf("geo:%.2f,%.2f", geofence.Latitude, geofence.Longitude)
+		return key, &BlacklistRule{
+			Geofence: geofence,
+			Comment:  trainComment("geofence cell"),
+		}
+
+	default:
+		return "ip:" + ip.String(), &BlacklistRule{
+			IP:      ip,
+			Comment: trainComment("IP " + ip.String()),
+		}
+	}
+}
+
+// trainComment formats the comment attached to a rule promoted by
+// training mode.
+func trainComment(what string) string {
+	return fmt.Sprintf("Automatically added by training (%s) on %s", what, time.Now().Format(time.RFC1123))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestTrainerScorePromotesAtThreshold confirms a candidate is scored across
+// repeated hits and only promoted to a BlacklistRule once it reaches
+// Threshold, returning nil on every hit before that and resetting the count
+// once the rule is promoted.
+func TestTrainerScorePromotesAtThreshold(t *testing.T) {
+	trainer := NewTrainer(time.Minute, 3, TrainScopeIP)
+	ctx := &BlacklistContext{}
+	ip := net.ParseIP("198.51.100.1")
+
+	if rule := trainer.Score(ctx, ip); rule != nil {
+		t.Fatalf("Score() hit 1 = %v, want nil", rule)
+	}
+	if rule := trainer.Score(ctx, ip); rule != nil {
+		t.Fatalf("Score() hit 2 = %v, want nil", rule)
+	}
+
+	rule := trainer.Score(ctx, ip)
+	if rule == nil {
+		t.Fatalf("Score() hit 3 = nil, want a promoted rule")
+	}
+	if rule.IP.String() != ip.String() {
+		t.Errorf("rule.IP = %v, want %v", rule.IP, ip)
+	}
+
+	// The candidate was deleted on promotion, so the next hit starts over.
+	if rule := trainer.Score(ctx, ip); rule != nil {
+		t.Errorf("Score() after promotion = %v, want nil (count should have reset)", rule)
+	}
+}
+
+// TestTrainerScoreDecays confirms a candidate not seen within Window is
+// reset instead of accumulating indefinitely.
+func TestTrainerScoreDecays(t *testing.T) {
+	trainer := NewTrainer(time.Minute, 2, TrainScopeIP)
+	ctx := &BlacklistContext{}
+	ip := net.ParseIP("198.51.100.2")
+
+	trainer.Score(ctx, ip)
+
+	key, _ := trainer.candidateKey(ctx, ip)
+	trainer.candidates[key].lastSeen = time.Now().Add(-2 * time.Minute)
+
+	if rule := trainer.Score(ctx, ip); rule != nil {
+		t.Fatalf("Score() after decay = %v, want nil: a decayed candidate should restart at count 1, not promote", rule)
+	}
+}
+
+// TestTrainerScoreConcurrent exercises Score from many goroutines at once,
+// guarding against the data race on candidates that a missing mutex would
+// otherwise produce under `go test -race`.
+func TestTrainerScoreConcurrent(t *testing.T) {
+	trainer := NewTrainer(time.Minute, 1000000, TrainScopeIP)
+	ctx := &BlacklistContext{}
+	ip := net.ParseIP("198.51.100.3")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			trainer.Score(ctx, ip)
+		}()
+	}
+	wg.Wait()
+}